@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// loaderFunc adapts a plain function to the KeyStoreCacheLoader interface,
+// which is handy for benchmarks and tests that don't need a real MockDB.
+type loaderFunc func(string) (string, error)
+
+func (f loaderFunc) Load(ctx context.Context, key string) (string, error) { return f(key) }
+
+// benchmarkCache hammers cache with concurrent Gets over a small, fixed
+// key space so that most requests hit already-cached entries.
+func benchmarkCache(b *testing.B, cache *KeyStoreCache) {
+	const keySpace = CacheSize
+
+	ctx := context.Background()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i uint64
+		for pb.Next() {
+			n := atomic.AddUint64(&i, 1)
+			cache.Get(ctx, fmt.Sprintf("key-%d", n%keySpace))
+		}
+	})
+}
+
+// BenchmarkCacheSingleShard measures throughput of the original
+// single-mutex cache under concurrent load.
+func BenchmarkCacheSingleShard(b *testing.B) {
+	load := loaderFunc(func(key string) (string, error) { return key, nil })
+	cache := New[string, string](load, CacheSize, WithShards[string, string](1))
+	defer cache.Close()
+
+	benchmarkCache(b, cache)
+}
+
+// BenchmarkCacheSharded measures throughput of the same workload spread
+// across 16 shards, demonstrating the contention reduction from sharding.
+func BenchmarkCacheSharded(b *testing.B) {
+	load := loaderFunc(func(key string) (string, error) { return key, nil })
+	cache := New[string, string](load, CacheSize, WithShards[string, string](16))
+	defer cache.Close()
+
+	benchmarkCache(b, cache)
+}
+
+// countingLoader counts how many times Load is called per key, so tests
+// can assert a cache hit or negative-cache entry suppressed a reload.
+type countingLoader struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	loadErr error
+}
+
+func (l *countingLoader) Load(ctx context.Context, key string) (string, error) {
+	l.mu.Lock()
+	if l.calls == nil {
+		l.calls = make(map[string]int)
+	}
+	l.calls[key]++
+	l.mu.Unlock()
+
+	if l.loadErr != nil {
+		return "", l.loadErr
+	}
+	return key, nil
+}
+
+func (l *countingLoader) callsFor(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls[key]
+}
+
+// TestTTLExpiry checks that an entry older than its TTL is treated as a
+// miss and reloaded.
+func TestTTLExpiry(t *testing.T) {
+	ctx := context.Background()
+	load := &countingLoader{}
+	cache := New[string, string](load, CacheSize, WithTTL[string, string](10*time.Millisecond))
+	defer cache.Close()
+
+	if _, err := cache.Get(ctx, "a"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if _, err := cache.Get(ctx, "a"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got := load.callsFor("a"); got != 1 {
+		t.Fatalf("calls before expiry = %d, want 1", got)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := cache.Get(ctx, "a"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got := load.callsFor("a"); got != 2 {
+		t.Fatalf("calls after expiry = %d, want 2", got)
+	}
+}
+
+// TestSieveSecondChance checks that a recently-touched entry survives an
+// eviction that a less recently touched entry does not.
+func TestSieveSecondChance(t *testing.T) {
+	ctx := context.Background()
+	load := &countingLoader{}
+	cache := New[string, string](load, CacheSize,
+		WithShards[string, string](1),
+		WithPolicy[string, string](PolicySIEVE))
+	defer cache.Close()
+
+	sh := cache.shards[0]
+	sh.maxSize = 2
+
+	cache.Get(ctx, "a")
+	cache.Get(ctx, "b")
+	cache.Get(ctx, "a") // mark "a" as visited
+
+	cache.Get(ctx, "c") // should evict "b", not "a"
+
+	if _, ok := sh.cache["a"]; !ok {
+		t.Fatal("\"a\" was evicted, want it to survive its second chance")
+	}
+	if _, ok := sh.cache["b"]; ok {
+		t.Fatal("\"b\" survived, want it evicted")
+	}
+
+	cache.Get(ctx, "a")
+	if got := load.callsFor("a"); got != 1 {
+		t.Fatalf("calls for surviving key = %d, want 1", got)
+	}
+}
+
+// TestStatsHitsMissesEvictions checks that Stats reflects hits, misses,
+// and evictions across Gets.
+func TestStatsHitsMissesEvictions(t *testing.T) {
+	ctx := context.Background()
+	load := &countingLoader{}
+	cache := New[string, string](load, CacheSize, WithShards[string, string](1))
+	defer cache.Close()
+
+	cache.shards[0].maxSize = 1
+
+	cache.Get(ctx, "a") // miss
+	cache.Get(ctx, "a") // hit
+	cache.Get(ctx, "b") // miss, evicts "a"
+
+	stats := cache.Stats()
+	if stats.Hits != 1 {
+		t.Errorf("Hits = %d, want 1", stats.Hits)
+	}
+	if stats.Misses != 2 {
+		t.Errorf("Misses = %d, want 2", stats.Misses)
+	}
+	if stats.Evictions != 1 {
+		t.Errorf("Evictions = %d, want 1", stats.Evictions)
+	}
+}
+
+// TestOnEvictCallback checks that the eviction callback fires for an
+// entry dropped to make room for a new one.
+func TestOnEvictCallback(t *testing.T) {
+	ctx := context.Background()
+	load := &countingLoader{}
+
+	var mu sync.Mutex
+	var evictedKey, evictedValue string
+	onEvict := func(key, value string) {
+		mu.Lock()
+		defer mu.Unlock()
+		evictedKey, evictedValue = key, value
+	}
+
+	cache := New[string, string](load, CacheSize,
+		WithShards[string, string](1),
+		WithEvictCallback[string, string](onEvict))
+	defer cache.Close()
+
+	cache.shards[0].maxSize = 1
+
+	cache.Get(ctx, "a")
+	cache.Get(ctx, "b")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if evictedKey != "a" || evictedValue != "a" {
+		t.Fatalf("onEvict got (%q, %q), want (\"a\", \"a\")", evictedKey, evictedValue)
+	}
+}
+
+// TestNegativeCacheSuppressesRetries checks that repeated lookups of a
+// key whose load fails don't reload until the negative entry expires.
+func TestNegativeCacheSuppressesRetries(t *testing.T) {
+	ctx := context.Background()
+	load := &countingLoader{loadErr: errors.New("backend unavailable")}
+	cache := New[string, string](load, CacheSize, WithNegativeTTL[string, string](50*time.Millisecond))
+	defer cache.Close()
+
+	if _, err := cache.Get(ctx, "a"); err == nil {
+		t.Fatal("Get: want error")
+	}
+	if _, err := cache.Get(ctx, "a"); err == nil {
+		t.Fatal("Get: want error")
+	}
+	if got := load.callsFor("a"); got != 1 {
+		t.Fatalf("calls while negative entry is fresh = %d, want 1", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if _, err := cache.Get(ctx, "a"); err == nil {
+		t.Fatal("Get: want error")
+	}
+	if got := load.callsFor("a"); got != 2 {
+		t.Fatalf("calls after negative entry expiry = %d, want 2", got)
+	}
+}
+
+// TestContextCancellation checks that Get returns the context's error
+// instead of blocking when ctx is cancelled before the load completes.
+func TestContextCancellation(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	load := loaderFunc(func(key string) (string, error) {
+		close(started)
+		<-unblock
+		return key, nil
+	})
+	cache := New[string, string](load, CacheSize)
+	defer cache.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(ctx, "a")
+		done <- err
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Get error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get did not return after ctx was cancelled")
+	}
+
+	close(unblock)
+}
+
+// ctxLoader calls a callback with the ctx it was given, then blocks until
+// unblock is closed, failing with ctx.Err() if ctx is cancelled first —
+// the way a real context-aware backend call behaves.
+type ctxLoader struct {
+	onLoad  func(context.Context)
+	unblock chan struct{}
+}
+
+func (l ctxLoader) Load(ctx context.Context, key string) (string, error) {
+	l.onLoad(ctx)
+	select {
+	case <-l.unblock:
+		return key, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// TestSingleflightIsolatesCallerContexts checks that canceling one
+// caller's ctx while a second caller is waiting on the same in-flight,
+// singleflight-shared load does not cancel the second caller too.
+func TestSingleflightIsolatesCallerContexts(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	var loadCount int32
+	load := ctxLoader{
+		onLoad: func(context.Context) {
+			if atomic.AddInt32(&loadCount, 1) == 1 {
+				close(started)
+			}
+		},
+		unblock: unblock,
+	}
+	cache := New[string, string](load, CacheSize)
+	defer cache.Close()
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(ctxA, "a")
+		doneA <- err
+	}()
+	<-started
+
+	doneB := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(context.Background(), "a")
+		doneB <- err
+	}()
+
+	cancelA()
+
+	select {
+	case err := <-doneA:
+		if err != context.Canceled {
+			t.Fatalf("caller A error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("caller A did not return after its ctx was cancelled")
+	}
+
+	close(unblock)
+
+	select {
+	case err := <-doneB:
+		if err != nil {
+			t.Fatalf("caller B error = %v, want nil (its ctx was never cancelled)", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("caller B did not return after the load finished")
+	}
+
+	if got := atomic.LoadInt32(&loadCount); got != 1 {
+		t.Fatalf("load ran %d times, want 1 (singleflight should have coalesced both callers)", got)
+	}
+}
+
+// TestCloseConcurrent checks that calling Close from multiple goroutines
+// at once doesn't panic on a double close of the stop channel.
+func TestCloseConcurrent(t *testing.T) {
+	load := loaderFunc(func(key string) (string, error) { return key, nil })
+	cache := New[string, string](load, CacheSize, WithTTL[string, string](time.Hour))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Close()
+		}()
+	}
+	wg.Wait()
+}